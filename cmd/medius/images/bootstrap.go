@@ -0,0 +1,83 @@
+package images
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1 "kubevirt.io/api/core/v1"
+	kvirtcli "kubevirt.io/client-go/kubecli"
+	"kubevirt.io/containerdisks/pkg/api"
+	"kubevirt.io/containerdisks/pkg/api/tests"
+)
+
+const (
+	BootstrapCheckNone = "none"
+	BootstrapCheckSSH  = "ssh"
+)
+
+// waitBootstrapComplete blocks until cloud-init has finished applying
+// UserData, so SSH-based tests don't flake by running against a guest
+// whose authorized_keys hasn't been written yet. vm.Status.Ready only means
+// the VMI was scheduled and qemu started; it says nothing about cloud-init.
+func waitBootstrapComplete(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	privateKey ed25519.PrivateKey,
+	mode string,
+	timeout int,
+) error {
+	switch mode {
+	case "", BootstrapCheckNone:
+		return nil
+	case BootstrapCheckSSH:
+		return waitBootstrapViaSSH(ctx, client, namespace, vmi, privateKey, timeout)
+	default:
+		return fmt.Errorf("unknown bootstrap check %q", mode)
+	}
+}
+
+func waitBootstrapViaSSH(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	privateKey ed25519.PrivateKey,
+	timeout int,
+) error {
+	spec := tests.TestSpec{
+		Name:          "bootstrap-check",
+		SSHCommand:    "cloud-init status --wait",
+		ExpectedRegex: "status: done",
+	}
+	params := &api.ArtifactTestParams{Username: VerifyUsername, PrivateKey: privateKey}
+
+	return pollBootstrap(ctx, timeout, func() error {
+		return spec.Execute(ctx, client, namespace, vmi, params)
+	})
+}
+
+func pollBootstrap(ctx context.Context, timeout int, check func() error) error {
+	var lastErr error
+	err := wait.PollImmediateWithContext(ctx, time.Second, time.Duration(timeout)*time.Second, func(_ context.Context) (bool, error) {
+		if err := check(); err != nil {
+			lastErr = err
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			return fmt.Errorf("bootstrap check did not complete within %ds, last error: %w", timeout, lastErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package images
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"time"
+)
+
+const (
+	ReportFormatNone  = "none"
+	ReportFormatJUnit = "junit"
+	ReportFormatJSON  = "json"
+)
+
+// testCaseReport captures the outcome of a single artifact test function,
+// in a shape that maps directly onto a JUnit <testcase>.
+type testCaseReport struct {
+	XMLName     xml.Name         `xml:"testcase" json:"-"`
+	ClassName   string           `xml:"classname,attr" json:"classname"`
+	Name        string           `xml:"name,attr" json:"name"`
+	DurationSec float64          `xml:"time,attr" json:"durationSeconds"`
+	Failure     *testCaseFailure `xml:"failure,omitempty" json:"failure,omitempty"`
+	SystemOut   string           `xml:"system-out,omitempty" json:"systemOut,omitempty"`
+}
+
+type testCaseFailure struct {
+	Message string `xml:"message,attr" json:"message"`
+	Content string `xml:",chardata" json:"content"`
+}
+
+// testSuiteReport groups all test cases executed for a single artifact.
+type testSuiteReport struct {
+	XMLName   xml.Name         `xml:"testsuite" json:"-"`
+	Name      string           `xml:"name,attr" json:"name"`
+	Tests     int              `xml:"tests,attr" json:"tests"`
+	Failures  int              `xml:"failures,attr" json:"failures"`
+	Time      float64          `xml:"time,attr" json:"durationSeconds"`
+	TestCases []testCaseReport `xml:"testcase" json:"testcases"`
+
+	// Attempts and LastErrorKind are filled in by verifyArtifactWithRetry so
+	// a report reader can tell a flaky pass from a first-try pass.
+	Attempts      int    `xml:"-" json:"attempts,omitempty"`
+	LastErrorKind string `xml:"-" json:"lastErrorKind,omitempty"`
+}
+
+type testSuitesReport struct {
+	XMLName xml.Name          `xml:"testsuites" json:"-"`
+	Suites  []testSuiteReport `xml:"testsuite" json:"testsuites"`
+}
+
+// testRun accumulates test case results for a single artifact as its tests
+// are executed, so a report can be emitted once verification finishes.
+type testRun struct {
+	artifactName string
+	cases        []testCaseReport
+}
+
+func newTestRun(artifactName string) *testRun {
+	return &testRun{artifactName: artifactName}
+}
+
+// record wraps a single testFn invocation, timing it and capturing the
+// failure message and any serial console output on failure.
+func (t *testRun) record(name string, consoleOutput func() string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	duration := time.Since(start)
+
+	tc := testCaseReport{
+		ClassName:   t.artifactName,
+		Name:        name,
+		DurationSec: duration.Seconds(),
+	}
+	if err != nil {
+		tc.Failure = &testCaseFailure{
+			Message: err.Error(),
+			Content: consoleOutput(),
+		}
+	}
+	t.cases = append(t.cases, tc)
+
+	return err
+}
+
+func (t *testRun) suite() testSuiteReport {
+	failures := 0
+	total := 0.0
+	for _, tc := range t.cases {
+		total += tc.DurationSec
+		if tc.Failure != nil {
+			failures++
+		}
+	}
+
+	return testSuiteReport{
+		Name:      t.artifactName,
+		Tests:     len(t.cases),
+		Failures:  failures,
+		Time:      total,
+		TestCases: t.cases,
+	}
+}
+
+// writeReportFile renders the collected suites in the requested format and
+// writes them to path. An empty format is a no-op, so --report-file can be
+// left unset without affecting the existing results file.
+func writeReportFile(format, path string, suites []testSuiteReport) error {
+	if format == "" || format == ReportFormatNone || path == "" {
+		return nil
+	}
+
+	var data []byte
+	var err error
+	switch format {
+	case ReportFormatJUnit:
+		data, err = xml.MarshalIndent(testSuitesReport{Suites: suites}, "", "  ")
+	case ReportFormatJSON:
+		data, err = json.MarshalIndent(testSuitesReport{Suites: suites}, "", "  ")
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
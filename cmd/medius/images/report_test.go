@@ -0,0 +1,91 @@
+package images
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeTestError struct{ msg string }
+
+func (e *fakeTestError) Error() string { return e.msg }
+
+func sampleSuite() testSuiteReport {
+	run := newTestRun("my-artifact")
+	_ = run.record("passing-test", func() string { return "" }, func() error { return nil })
+	_ = run.record("failing-test", func() string { return "serial console output" }, func() error {
+		return &fakeTestError{"assertion failed"}
+	})
+
+	return run.suite()
+}
+
+func TestTestRunSuiteCountsFailures(t *testing.T) {
+	suite := sampleSuite()
+
+	if suite.Tests != 2 {
+		t.Fatalf("expected 2 tests, got %d", suite.Tests)
+	}
+	if suite.Failures != 1 {
+		t.Fatalf("expected 1 failure, got %d", suite.Failures)
+	}
+	if suite.TestCases[1].Failure == nil {
+		t.Fatalf("expected second testcase to have a recorded failure")
+	}
+	if suite.TestCases[1].Failure.Content != "serial console output" {
+		t.Fatalf("expected failure content to carry console output, got %q", suite.TestCases[1].Failure.Content)
+	}
+}
+
+func TestWriteReportFileJUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.xml")
+
+	if err := writeReportFile(ReportFormatJUnit, path, []testSuiteReport{sampleSuite()}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+
+	var parsed testSuitesReport
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("expected valid JUnit XML, got error %v:\n%s", err, data)
+	}
+	if len(parsed.Suites) != 1 || parsed.Suites[0].Tests != 2 {
+		t.Fatalf("unexpected parsed report: %+v", parsed)
+	}
+}
+
+func TestWriteReportFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+
+	if err := writeReportFile(ReportFormatJSON, path, []testSuiteReport{sampleSuite()}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+
+	var parsed testSuitesReport
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("expected valid JSON, got error %v:\n%s", err, data)
+	}
+	if len(parsed.Suites) != 1 || parsed.Suites[0].Failures != 1 {
+		t.Fatalf("unexpected parsed report: %+v", parsed)
+	}
+}
+
+func TestWriteReportFileNoopWithoutPathOrFormat(t *testing.T) {
+	if err := writeReportFile("", "", []testSuiteReport{sampleSuite()}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := writeReportFile(ReportFormatJUnit, "", []testSuiteReport{sampleSuite()}); err != nil {
+		t.Fatalf("expected no error when path is empty, got %v", err)
+	}
+}
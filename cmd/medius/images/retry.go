@@ -0,0 +1,169 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kvirtcli "kubevirt.io/client-go/kubecli"
+	"kubevirt.io/containerdisks/cmd/medius/common"
+	"kubevirt.io/containerdisks/pkg/api"
+	"kubevirt.io/containerdisks/pkg/api/tests"
+)
+
+// errorKind classifies why a verification attempt failed, so
+// verifyArtifactWithRetry knows whether retrying could plausibly help.
+type errorKind string
+
+const (
+	errorKindNone      errorKind = ""
+	errorKindConflict  errorKind = "conflict"
+	errorKindTimeout   errorKind = "timeout"
+	errorKindImagePull errorKind = "image-pull-backoff"
+	errorKindTerminal  errorKind = "terminal"
+
+	retryInitialInterval = 5 * time.Second
+	retryFactor          = 2.0
+	retryMaxElapsed      = 20 * time.Minute
+)
+
+// schedulingTimeoutError marks a waitVMReady timeout observed while the VMI
+// had not yet got past the Scheduling phase, e.g. because the cluster is
+// still working through a quota or node-selection backlog. It is retryable.
+type schedulingTimeoutError struct {
+	cause error
+}
+
+func (e *schedulingTimeoutError) Error() string { return e.cause.Error() }
+func (e *schedulingTimeoutError) Unwrap() error { return e.cause }
+
+// bootTimeoutError marks a waitVMReady timeout observed after the VMI had
+// already moved past Scheduling, meaning the cluster placed it fine and the
+// guest itself never came up. Retrying wastes a full --timeout per attempt
+// against an image that isn't going to boot, so this is terminal.
+type bootTimeoutError struct {
+	cause error
+}
+
+func (e *bootTimeoutError) Error() string { return e.cause.Error() }
+func (e *bootTimeoutError) Unwrap() error { return e.cause }
+
+// classifyError turns a verifyArtifact error into the errorKind an operator
+// would use with --retry-on. Anything not recognized as a cluster hiccup is
+// treated as terminal, e.g. a failed test assertion or an SSH auth failure.
+func classifyError(err error) errorKind {
+	if err == nil {
+		return errorKindNone
+	}
+	if k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err) {
+		return errorKindConflict
+	}
+
+	var schedulingErr *schedulingTimeoutError
+	if errors.As(err, &schedulingErr) {
+		return errorKindTimeout
+	}
+	var bootErr *bootTimeoutError
+	if errors.As(err, &bootErr) {
+		return errorKindTerminal
+	}
+	// A timeout we couldn't attribute to either phase is treated as
+	// terminal rather than retryable, since retrying a genuinely unbootable
+	// image wastes --max-retries * --timeout for no benefit.
+	if errors.Is(err, wait.ErrWaitTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return errorKindTerminal
+	}
+	if strings.Contains(err.Error(), "ImagePullBackOff") || strings.Contains(err.Error(), "ErrImagePull") {
+		return errorKindImagePull
+	}
+
+	return errorKindTerminal
+}
+
+// retryable reports whether kind is in the operator-configured set of
+// retryable classifications.
+func retryable(kind errorKind, retryOn []string) bool {
+	for _, allowed := range retryOn {
+		if errorKind(strings.TrimSpace(allowed)) == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyArtifactWithRetry wraps verifyArtifact in exponential backoff,
+// retrying transient cluster-side failures (image pull throttling, Create
+// conflicts, a VM that never reached Scheduling) while giving up immediately
+// on terminal failures like a failed test assertion. The returned
+// ArtifactResult always reflects the last attempt.
+func verifyArtifactWithRetry(
+	ctx context.Context,
+	artifact api.Artifact,
+	result api.ArtifactResult,
+	options *common.Options,
+	client kvirtcli.KubevirtClient,
+	extraSpecs []tests.TestSpec,
+	runID string,
+) (*api.ArtifactResult, *testSuiteReport, error) {
+	log := common.Logger(artifact)
+
+	backoffStep := wait.Backoff{
+		Duration: retryInitialInterval,
+		Factor:   retryFactor,
+		Steps:    options.VerifyImagesOptions.MaxRetries,
+	}
+
+	start := time.Now()
+	var (
+		artifactResult *api.ArtifactResult
+		suite          *testSuiteReport
+		err            error
+	)
+
+	for attempt := 1; ; attempt++ {
+		artifactResult, suite, err = verifyArtifact(ctx, artifact, result, options, client, extraSpecs, runID)
+		kind := classifyError(err)
+		if suite != nil {
+			suite.Attempts = attempt
+			suite.LastErrorKind = string(kind)
+		}
+		if artifactResult != nil {
+			artifactResult.Attempts = attempt
+			artifactResult.LastErrorKind = string(kind)
+		}
+
+		if err == nil {
+			return artifactResult, suite, nil
+		}
+
+		elapsed := time.Since(start)
+
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return artifactResult, suite, nil
+		}
+		if !retryable(kind, options.VerifyImagesOptions.RetryOn) {
+			log.WithError(err).Errorf("Verification failed with non-retryable error kind %q after %d attempt(s)", kind, attempt)
+			return artifactResult, suite, err
+		}
+		if attempt >= options.VerifyImagesOptions.MaxRetries || elapsed >= retryMaxElapsed {
+			log.WithError(err).Errorf("Verification failed with retryable error kind %q, giving up after %d attempt(s)", kind, attempt)
+			return artifactResult, suite, err
+		}
+
+		sleep := backoffStep.Step()
+		log.WithError(err).Warnf(
+			"Verification attempt %d failed with retryable error kind %q, retrying in %s",
+			attempt, kind, sleep,
+		)
+
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return artifactResult, suite, nil
+		}
+	}
+}
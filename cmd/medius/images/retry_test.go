@@ -0,0 +1,70 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestClassifyError(t *testing.T) {
+	conflict := k8serrors.NewConflict(schema.GroupResource{Resource: "virtualmachines"}, "my-vm", errors.New("conflict"))
+
+	cases := []struct {
+		name string
+		err  error
+		want errorKind
+	}{
+		{name: "nil error", err: nil, want: errorKindNone},
+		{name: "create conflict", err: conflict, want: errorKindConflict},
+		{
+			name: "scheduling timeout",
+			err:  &schedulingTimeoutError{cause: errors.New("timed out waiting for the condition")},
+			want: errorKindTimeout,
+		},
+		{
+			name: "boot timeout",
+			err:  &bootTimeoutError{cause: errors.New("timed out waiting for the condition")},
+			want: errorKindTerminal,
+		},
+		{
+			name: "unattributed deadline exceeded",
+			err:  context.DeadlineExceeded,
+			want: errorKindTerminal,
+		},
+		{
+			name: "image pull backoff",
+			err:  errors.New(`Failed to pull image "quay.io/foo:bar": ImagePullBackOff`),
+			want: errorKindImagePull,
+		},
+		{
+			name: "failed test assertion",
+			err:  errors.New("test \"guest-reachable\": output did not match"),
+			want: errorKindTerminal,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := classifyError(c.err); got != c.want {
+				t.Fatalf("classifyError() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	retryOn := []string{"conflict", " timeout "}
+
+	if !retryable(errorKindConflict, retryOn) {
+		t.Fatalf("expected %q to be retryable", errorKindConflict)
+	}
+	if !retryable(errorKindTimeout, retryOn) {
+		t.Fatalf("expected %q to be retryable after trimming whitespace", errorKindTimeout)
+	}
+	if retryable(errorKindTerminal, retryOn) {
+		t.Fatalf("expected %q not to be retryable", errorKindTerminal)
+	}
+}
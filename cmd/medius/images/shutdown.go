@@ -0,0 +1,94 @@
+package images
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvirtcli "kubevirt.io/client-go/kubecli"
+)
+
+const (
+	// runLabelKey tags every namespace and VM created by a verify run, so a
+	// crashed run's leftovers can be found and force-deleted later, either
+	// by --gc-stale on the next invocation or by forceDeleteRun below.
+	runLabelKey = "containerdisks.kubevirt.io/verify-run"
+)
+
+// trapShutdown cancels cancel on SIGINT/SIGTERM/SIGQUIT so in-flight
+// verifications unwind through their defers and delete their own VMs and
+// namespaces. A second signal force-deletes everything tagged with runID
+// across all namespaces, for stragglers whose defers didn't get to run. A
+// third signal gives up waiting and exits with a stack dump.
+func trapShutdown(ctx context.Context, cancel context.CancelFunc, client kvirtcli.KubevirtClient, runID string) {
+	sigCh := make(chan os.Signal, 3)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	go func() {
+		<-sigCh
+		logrus.Warn("Received shutdown signal, cancelling in-flight verifications")
+		cancel()
+
+		<-sigCh
+		logrus.Warn("Received second shutdown signal, force-deleting VMs for this run")
+		if err := forceDeleteRun(client, runID); err != nil {
+			logrus.WithError(err).Error("Failed to force-delete run resources")
+		}
+
+		<-sigCh
+		logrus.Warn("Received third shutdown signal, exiting immediately")
+		debug.PrintStack()
+		os.Exit(1)
+	}()
+}
+
+// forceDeleteRun deletes every namespace labeled with the given verify-run
+// ID, regardless of in-flight state. Namespace deletion cascades to the VMs
+// and VMIs it contains.
+func forceDeleteRun(client kvirtcli.KubevirtClient, runID string) error {
+	namespaces, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{
+		LabelSelector: runLabelKey + "=" + runID,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces.Items {
+		if err := client.CoreV1().Namespaces().Delete(context.Background(), ns.Name, metav1.DeleteOptions{
+			GracePeriodSeconds: new(int64),
+		}); err != nil {
+			logrus.WithError(err).Errorf("Failed to force-delete namespace %s", ns.Name)
+		}
+	}
+
+	return nil
+}
+
+// gcStaleRuns deletes namespaces left behind by verify runs that crashed
+// before cleaning up after themselves, i.e. anything still carrying
+// runLabelKey from a run other than the current one.
+func gcStaleRuns(client kvirtcli.KubevirtClient, currentRunID string) error {
+	namespaces, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{
+		LabelSelector: runLabelKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range namespaces.Items {
+		if ns.Labels[runLabelKey] == currentRunID {
+			continue
+		}
+
+		logrus.Infof("Garbage-collecting stale verify namespace %s", ns.Name)
+		if err := client.CoreV1().Namespaces().Delete(context.Background(), ns.Name, metav1.DeleteOptions{}); err != nil {
+			logrus.WithError(err).Errorf("Failed to garbage-collect namespace %s", ns.Name)
+		}
+	}
+
+	return nil
+}
@@ -1,18 +1,25 @@
 package images
 
 import (
+	"bytes"
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"errors"
+	"fmt"
+	"io"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	urand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/utils/pointer"
 	v1 "kubevirt.io/api/core/v1"
@@ -20,28 +27,58 @@ import (
 	kvirtlog "kubevirt.io/client-go/log"
 	"kubevirt.io/containerdisks/cmd/medius/common"
 	"kubevirt.io/containerdisks/pkg/api"
+	"kubevirt.io/containerdisks/pkg/api/tests"
 	"kubevirt.io/containerdisks/pkg/docs"
 )
 
 const (
 	VerifyUsername = "verify"
+
+	// DefaultParallelism bounds how many artifacts are verified
+	// concurrently when --parallelism is left unset.
+	DefaultParallelism = 4
+
+	namespacePrefix = "containerdisks-verify-"
 )
 
+// reportResult pairs a test suite report with the artifact it was collected
+// for, so reports can be gathered off the same fan-in channel pattern used
+// for ArtifactResults.
+type reportResult struct {
+	Key   string
+	Value testSuiteReport
+}
+
 func NewVerifyImagesCommand(options *common.Options) *cobra.Command {
 	options.VerifyImagesOptions = common.VerifyImageOptions{
-		Namespace: "kubevirt",
-		Timeout:   600,
+		Namespace:        "kubevirt",
+		Timeout:          600,
+		Parallelism:      DefaultParallelism,
+		BootstrapCheck:   BootstrapCheckSSH,
+		BootstrapTimeout: 300,
+		MaxRetries:       5,
+		RetryOn:          []string{string(errorKindConflict), string(errorKindTimeout), string(errorKindImagePull)},
 	}
 
 	verifyCmd := &cobra.Command{
 		Use:   "verify",
 		Short: "Verify that containerdisks are bootable and guests are working",
 		Run: func(cmd *cobra.Command, args []string) {
+			reportFormat := options.VerifyImagesOptions.ReportFormat
+			if reportFormat != "" && reportFormat != ReportFormatNone && options.VerifyImagesOptions.ReportFile == "" {
+				logrus.Fatalf("--report-file is required when --report-format=%s is set", reportFormat)
+			}
+
 			results, err := readResultsFile(options.ImagesOptions.ResultsFile)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 
+			extraSpecs, err := tests.LoadDir(options.VerifyImagesOptions.TestsDir)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
 			// Silence the kubevirt client log
 			kvirtlog.Log = kvirtlog.MakeLogger(kvirtlog.NullLogger{})
 			client, err := kvirtcli.GetKubevirtClient()
@@ -49,24 +86,50 @@ func NewVerifyImagesCommand(options *common.Options) *cobra.Command {
 				logrus.Fatal(err)
 			}
 
+			runID := string(uuid.NewUUID())
+
+			if options.VerifyImagesOptions.GCStale {
+				if err := gcStaleRuns(client, runID); err != nil {
+					logrus.WithError(err).Error("Failed to garbage-collect stale verify runs")
+				}
+			}
+
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+			trapShutdown(ctx, cancel, client, runID)
+
 			resultsChan := make(chan workerResult, len(common.Registry))
-			err = spawnWorkers(cmd.Context(), options, func(a api.Artifact) error {
+			reportsChan := make(chan reportResult, len(common.Registry))
+			// spawnWorkers itself is unbounded, so cap how many verifications
+			// run concurrently with a semaphore sized by --parallelism.
+			parallelism := make(chan struct{}, options.VerifyImagesOptions.Parallelism)
+			err = spawnWorkers(ctx, options, func(a api.Artifact) error {
 				r, ok := results[a.Metadata().Describe()]
 				if !ok || r.Verified {
 					return nil
 				}
 
-				result, err := verifyArtifact(cmd.Context(), a, r, options, client)
+				parallelism <- struct{}{}
+				defer func() { <-parallelism }()
+
+				result, suite, err := verifyArtifactWithRetry(ctx, a, r, options, client, extraSpecs, runID)
 				if result != nil {
 					resultsChan <- workerResult{
 						Key:   a.Metadata().Describe(),
 						Value: *result,
 					}
 				}
+				if suite != nil {
+					reportsChan <- reportResult{
+						Key:   a.Metadata().Describe(),
+						Value: *suite,
+					}
+				}
 
 				return err
 			})
 			close(resultsChan)
+			close(reportsChan)
 
 			for result := range resultsChan {
 				results[result.Key] = result.Value
@@ -76,41 +139,125 @@ func NewVerifyImagesCommand(options *common.Options) *cobra.Command {
 				logrus.Fatal(err)
 			}
 
+			var suites []testSuiteReport
+			for report := range reportsChan {
+				suites = append(suites, report.Value)
+			}
+
+			if err := writeReportFile(
+				options.VerifyImagesOptions.ReportFormat,
+				options.VerifyImagesOptions.ReportFile,
+				suites,
+			); err != nil {
+				logrus.Fatal(err)
+			}
+
 			if err != nil {
 				logrus.Fatal(err)
 			}
 		},
 	}
-	verifyCmd.Flags().StringVar(&options.VerifyImagesOptions.Namespace, "namespace", options.VerifyImagesOptions.Namespace, "Namespace to run verify in")
+	verifyCmd.Flags().StringVar(
+		&options.VerifyImagesOptions.Namespace,
+		"namespace",
+		options.VerifyImagesOptions.Namespace,
+		"Base namespace label applied to each run's ephemeral per-artifact namespaces; VMs do not run in this namespace directly",
+	)
 	verifyCmd.Flags().IntVar(&options.VerifyImagesOptions.Timeout, "timeout", options.VerifyImagesOptions.Timeout, "Maximum seconds to wait for VM to be running")
+	verifyCmd.Flags().StringVar(&options.VerifyImagesOptions.ReportFormat, "report-format", "", "Emit a structured test report in this format in addition to the results file (none, junit, json)")
+	verifyCmd.Flags().StringVar(&options.VerifyImagesOptions.ReportFile, "report-file", "", "Path to write the test report to, required if --report-format is set")
+	verifyCmd.Flags().IntVar(
+		&options.VerifyImagesOptions.Parallelism,
+		"parallelism",
+		options.VerifyImagesOptions.Parallelism,
+		"Maximum number of artifacts to verify concurrently, each in its own ephemeral namespace",
+	)
+	verifyCmd.Flags().StringVar(
+		&options.VerifyImagesOptions.TestsDir,
+		"tests-dir",
+		"",
+		"Directory of YAML TestSpecs to run in addition to an artifact's built-in tests",
+	)
+	verifyCmd.Flags().BoolVar(
+		&options.VerifyImagesOptions.GCStale,
+		"gc-stale",
+		false,
+		"Delete namespaces left behind by verify runs that crashed before cleaning up",
+	)
+	verifyCmd.Flags().StringVar(
+		&options.VerifyImagesOptions.BootstrapCheck,
+		"bootstrap-check",
+		options.VerifyImagesOptions.BootstrapCheck,
+		"How to confirm cloud-init has finished before running tests (none, ssh)",
+	)
+	verifyCmd.Flags().IntVar(
+		&options.VerifyImagesOptions.BootstrapTimeout,
+		"bootstrap-timeout",
+		options.VerifyImagesOptions.BootstrapTimeout,
+		"Maximum seconds to wait for the bootstrap check to pass",
+	)
+	verifyCmd.Flags().IntVar(
+		&options.VerifyImagesOptions.MaxRetries,
+		"max-retries",
+		options.VerifyImagesOptions.MaxRetries,
+		"Maximum number of attempts for a verification that keeps failing with a retryable error",
+	)
+	verifyCmd.Flags().StringSliceVar(
+		&options.VerifyImagesOptions.RetryOn,
+		"retry-on",
+		options.VerifyImagesOptions.RetryOn,
+		"Error kinds to retry (conflict, timeout, image-pull-backoff)",
+	)
 	verifyCmd.Flags().AddGoFlagSet(kvirtcli.FlagSet())
 
 	return verifyCmd
 }
 
-func verifyArtifact(ctx context.Context, artifact api.Artifact, result api.ArtifactResult, options *common.Options, client kvirtcli.KubevirtClient) (*api.ArtifactResult, error) {
+func verifyArtifact(
+	ctx context.Context,
+	artifact api.Artifact,
+	result api.ArtifactResult,
+	options *common.Options,
+	client kvirtcli.KubevirtClient,
+	extraSpecs []tests.TestSpec,
+	runID string,
+) (*api.ArtifactResult, *testSuiteReport, error) {
 	log := common.Logger(artifact)
+	run := newTestRun(artifact.Metadata().Describe())
 
 	if len(result.Tags) == 0 {
 		log.Infof("No containerdisks to verify")
-		return nil, nil
+		return nil, nil, nil
+	}
+
+	namespace, err := createVerifyNamespace(client, options.VerifyImagesOptions.Namespace, runID)
+	if err != nil {
+		log.WithError(err).Error("Failed to create verify namespace")
+		return nil, nil, err
 	}
+	log = log.WithField("namespace", namespace)
+
+	defer func() {
+		if err := client.CoreV1().Namespaces().Delete(context.Background(), namespace, metav1.DeleteOptions{}); err != nil {
+			log.WithError(err).Error("Failed to delete verify namespace")
+		}
+	}()
 
 	imgRef := path.Join(options.Registry, result.Tags[0])
-	vm, privateKey, err := createVM(artifact, imgRef)
+	vm, privateKey, err := createVM(artifact, imgRef, runID)
 	if err != nil {
 		log.WithError(err).Error("Failed to create VM object")
-		return nil, err
+		return nil, nil, err
 	}
 	if errors.Is(ctx.Err(), context.Canceled) {
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	vmClient := client.VirtualMachine(options.VerifyImagesOptions.Namespace)
+	vmClient := client.VirtualMachine(namespace)
 	log.Info("Creating VM")
-	if vm, err = vmClient.Create(vm); err != nil {
+	if vm, err = createVMWithBackoff(ctx, vmClient, vm); err != nil {
 		log.WithError(err).Error("Failed to create VM")
-		return nil, err
+		return nil, nil, err
 	}
 
 	defer func() {
@@ -120,47 +267,171 @@ func verifyArtifact(ctx context.Context, artifact api.Artifact, result api.Artif
 	}()
 
 	if errors.Is(ctx.Err(), context.Canceled) {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	log.Info("Waiting for VM to be ready")
 	if err = waitVMReady(ctx, vm.Name, vmClient, options.VerifyImagesOptions.Timeout); err != nil {
 		if errors.Is(ctx.Err(), context.Canceled) {
-			return nil, nil
+			return nil, nil, nil
 		}
 
+		err = classifyReadyTimeout(client, namespace, vm.Name, err)
 		log.WithError(err).Error("VM not ready")
-		return nil, err
+		return nil, nil, err
 	}
 
-	vmi, err := client.VirtualMachineInstance(options.VerifyImagesOptions.Namespace).Get(vm.Name, &metav1.GetOptions{})
+	vmi, err := client.VirtualMachineInstance(namespace).Get(vm.Name, &metav1.GetOptions{})
 	if err != nil {
 		log.WithError(err).Error("Failed to get VMI")
-		return nil, err
+		return nil, nil, err
 	}
 	if errors.Is(ctx.Err(), context.Canceled) {
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	log.Info("Running tests on VMI")
-	for _, testFn := range artifact.Tests() {
-		if err = testFn(ctx, vmi, &api.ArtifactTestParams{Username: VerifyUsername, PrivateKey: privateKey}); err != nil {
+	testParams := &api.ArtifactTestParams{Username: VerifyUsername, PrivateKey: privateKey}
+
+	log.Infof("Waiting for bootstrap to complete (%s)", options.VerifyImagesOptions.BootstrapCheck)
+	err = run.record("bootstrap-check", func() string {
+		return diagnostics(client, namespace, vmi.Name)
+	}, func() error {
+		return waitBootstrapComplete(
+			ctx, client, namespace, vmi, privateKey,
+			options.VerifyImagesOptions.BootstrapCheck,
+			options.VerifyImagesOptions.BootstrapTimeout,
+		)
+	})
+	if err != nil {
+		log.WithError(err).Error("Bootstrap check failed")
+		suite := run.suite()
+		return nil, &suite, err
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, nil, nil
+	}
+
+	log.Info("Running declarative test specs")
+	for _, spec := range append(append([]tests.TestSpec{}, tests.Builtin...), extraSpecs...) {
+		spec := spec
+		err = run.record(spec.Name, func() string {
+			return diagnostics(client, namespace, vmi.Name)
+		}, func() error {
+			return spec.Execute(ctx, client, namespace, vmi, testParams)
+		})
+		if err != nil {
 			log.WithError(err).Error("Failed to verify containerdisk")
-			return nil, err
+			suite := run.suite()
+			return nil, &suite, err
 		}
 		if errors.Is(ctx.Err(), context.Canceled) {
-			return nil, nil
+			return nil, nil, nil
 		}
 	}
 
 	log.Info("Tests successful")
+	suite := run.suite()
 	return &api.ArtifactResult{
 		Tags:     result.Tags,
 		Verified: true,
-	}, nil
+	}, &suite, nil
+}
+
+// diagnostics best-effort collects VMI events and serial console output for
+// inclusion in a failed testcase's report, so CI failures can be triaged
+// without re-running the verification.
+func diagnostics(client kvirtcli.KubevirtClient, namespace, vmiName string) string {
+	var out strings.Builder
+
+	events, err := client.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.name=%s", vmiName),
+	})
+	if err == nil {
+		for _, event := range events.Items {
+			fmt.Fprintf(&out, "[event] %s: %s\n", event.Reason, event.Message)
+		}
+	}
+
+	console, err := client.VirtualMachineInstance(namespace).SerialConsole(vmiName, &kvirtcli.SerialConsoleOptions{
+		ConnectionTimeout: 5 * time.Second,
+	})
+	if err == nil {
+		conn := console.AsConn()
+		defer conn.Close()
+
+		if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err == nil {
+			var buf bytes.Buffer
+			_, _ = io.Copy(&buf, conn)
+			if buf.Len() > 0 {
+				out.WriteString("[console]\n")
+				out.Write(buf.Bytes())
+			}
+		}
+	}
+
+	return out.String()
+}
+
+// createVerifyNamespace creates an ephemeral, uniquely-named namespace for a
+// single artifact verification, mirroring how e2e frameworks isolate
+// parallel test runs from one another. base is used as a label value only;
+// the namespace itself is always freshly named so concurrent verifications
+// never collide.
+func createVerifyNamespace(client kvirtcli.KubevirtClient, base, runID string) (string, error) {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: namespacePrefix,
+			Labels: map[string]string{
+				"containerdisks.kubevirt.io/base-namespace": base,
+				runLabelKey: runID,
+			},
+		},
+	}
+
+	created, err := client.CoreV1().Namespaces().Create(context.Background(), ns, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return created.Name, nil
+}
+
+// createVMWithBackoff retries vmClient.Create with jittered exponential
+// backoff when the cluster reports a conflict, which can happen when a
+// stale VM from a prior crashed run hasn't finished terminating yet.
+func createVMWithBackoff(ctx context.Context, vmClient kvirtcli.VirtualMachineInterface, vm *v1.VirtualMachine) (*v1.VirtualMachine, error) {
+	backoff := wait.Backoff{
+		Duration: 2 * time.Second,
+		Factor:   2,
+		Jitter:   0.5,
+		Steps:    5,
+	}
+
+	var created *v1.VirtualMachine
+	var lastConflict error
+	err := wait.ExponentialBackoffWithContext(ctx, backoff, func(_ context.Context) (bool, error) {
+		var err error
+		created, err = vmClient.Create(vm)
+		if err == nil {
+			return true, nil
+		}
+		if k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err) {
+			lastConflict = err
+			return false, nil
+		}
+
+		return false, err
+	})
+	// Return the conflict itself rather than the backoff's timeout, so
+	// classifyError still sees a conflict once the backoff is exhausted.
+	if errors.Is(err, wait.ErrWaitTimeout) && lastConflict != nil {
+		return created, lastConflict
+	}
+
+	return created, err
 }
 
-func createVM(artifact api.Artifact, imgRef string) (*v1.VirtualMachine, ed25519.PrivateKey, error) {
+func createVM(artifact api.Artifact, imgRef, runID string) (*v1.VirtualMachine, ed25519.PrivateKey, error) {
 	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, nil, err
@@ -179,7 +450,13 @@ func createVM(artifact api.Artifact, imgRef string) (*v1.VirtualMachine, ed25519
 	)
 
 	name := randName(artifact.Metadata().Name)
-	return artifact.VM(name, imgRef, userData), privateKey, nil
+	vm := artifact.VM(name, imgRef, userData)
+	if vm.Labels == nil {
+		vm.Labels = map[string]string{}
+	}
+	vm.Labels[runLabelKey] = runID
+
+	return vm, privateKey, nil
 }
 
 func marshallPublicKey(key *ed25519.PrivateKey) (string, error) {
@@ -196,6 +473,20 @@ func randName(name string) string {
 	return name + "-" + urand.String(5)
 }
 
+// classifyReadyTimeout tags a waitVMReady error with whether the VMI ever
+// got past the Scheduling phase, while the namespace it lives in still
+// exists. verifyArtifactWithRetry uses the tag to retry a cluster-side
+// scheduling backlog but give up immediately on an image that was
+// scheduled fine and simply never booted.
+func classifyReadyTimeout(client kvirtcli.KubevirtClient, namespace, name string, cause error) error {
+	vmi, err := client.VirtualMachineInstance(namespace).Get(name, &metav1.GetOptions{})
+	if err != nil || vmi.Status.Phase == "" || vmi.Status.Phase == v1.Pending || vmi.Status.Phase == v1.Scheduling {
+		return &schedulingTimeoutError{cause: cause}
+	}
+
+	return &bootTimeoutError{cause: cause}
+}
+
 func waitVMReady(ctx context.Context, name string, client kvirtcli.VirtualMachineInterface, timeout int) error {
 	return wait.PollImmediateWithContext(ctx, time.Second, time.Duration(timeout)*time.Second, func(_ context.Context) (bool, error) {
 		vm, err := client.Get(name, &metav1.GetOptions{})
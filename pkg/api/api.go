@@ -0,0 +1,14 @@
+package api
+
+// ArtifactResult records the outcome of verifying a single artifact and is
+// persisted to the results file between runs.
+type ArtifactResult struct {
+	Tags     []string `json:"tags"`
+	Verified bool     `json:"verified"`
+
+	// Attempts and LastErrorKind are filled in by verifyArtifactWithRetry so
+	// a reader of the results file can tell a flaky pass apart from a clean
+	// first-try pass, mirroring testSuiteReport's fields of the same name.
+	Attempts      int    `json:"attempts,omitempty"`
+	LastErrorKind string `json:"lastErrorKind,omitempty"`
+}
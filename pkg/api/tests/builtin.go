@@ -0,0 +1,13 @@
+package tests
+
+// Builtin mirrors the checks verifyArtifact used to run as hard-coded Go
+// closures, expressed as TestSpecs so they execute through the same path
+// as specs loaded from --tests-dir. cloud-init completion is not repeated
+// here: the "ssh" bootstrap-check already waits on "cloud-init status
+// --wait" before any of these specs run.
+var Builtin = []TestSpec{
+	{
+		Name:       "guest-reachable",
+		SSHCommand: "true",
+	},
+}
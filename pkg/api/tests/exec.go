@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+
+	"golang.org/x/crypto/ssh"
+	v1 "kubevirt.io/api/core/v1"
+	kvirtcli "kubevirt.io/client-go/kubecli"
+	"kubevirt.io/containerdisks/pkg/api"
+)
+
+const sshPort = 22
+
+// Execute runs the spec's check against vmi and returns an error describing
+// the failure, in the same style as the closures returned by
+// api.Artifact.Tests().
+func (s TestSpec) Execute(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	params *api.ArtifactTestParams,
+) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case s.SSHCommand != "":
+		return s.runSSHCommand(ctx, client, namespace, vmi, params)
+	case s.FileExists != "":
+		return s.checkFileExists(ctx, client, namespace, vmi, params)
+	case s.SystemdUnitActive != "":
+		return s.checkSystemdUnit(ctx, client, namespace, vmi, params)
+	case s.HTTPProbe != nil:
+		return s.probeHTTP(ctx, client, namespace, vmi, params)
+	default:
+		return fmt.Errorf("test spec %q has no check configured", s.Name)
+	}
+}
+
+func (s TestSpec) runSSHCommand(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	params *api.ArtifactTestParams,
+) error {
+	session, closers, err := dialSSH(ctx, client, namespace, vmi, params)
+	defer func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("test %q: %w", s.Name, err)
+	}
+
+	out, err := session.CombinedOutput(s.SSHCommand)
+	return evaluateSSHResult(s.Name, s.SSHCommand, out, err, s.ExpectedExitCode, s.ExpectedRegex)
+}
+
+// exitStatusError is satisfied by *ssh.ExitError; declaring it locally lets
+// evaluateSSHResult be unit tested with a fake exit error instead of a live
+// SSH session.
+type exitStatusError interface {
+	ExitStatus() int
+}
+
+// evaluateSSHResult turns the raw output/error of an SSH command into the
+// test's pass/fail verdict, separated from runSSHCommand so it can be unit
+// tested without a live SSH session.
+func evaluateSSHResult(name, command string, out []byte, runErr error, expectedExitCode *int, expectedRegex string) error {
+	expectedExit := 0
+	if expectedExitCode != nil {
+		expectedExit = *expectedExitCode
+	}
+
+	exitErr, isExitErr := runErr.(exitStatusError)
+	switch {
+	case runErr != nil && !isExitErr:
+		return fmt.Errorf("test %q: failed to run %q: %w", name, command, runErr)
+	case isExitErr && exitErr.ExitStatus() != expectedExit:
+		return fmt.Errorf("test %q: %q exited %d, expected %d: %s", name, command, exitErr.ExitStatus(), expectedExit, out)
+	case runErr == nil && expectedExit != 0:
+		return fmt.Errorf("test %q: %q exited 0, expected %d", name, command, expectedExit)
+	}
+
+	if expectedRegex != "" {
+		matched, err := regexp.Match(expectedRegex, out)
+		if err != nil {
+			return fmt.Errorf("test %q: invalid expectedRegex %q: %w", name, expectedRegex, err)
+		}
+		if !matched {
+			return fmt.Errorf("test %q: output %q did not match %q", name, out, expectedRegex)
+		}
+	}
+
+	return nil
+}
+
+func (s TestSpec) checkFileExists(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	params *api.ArtifactTestParams,
+) error {
+	probe := TestSpec{Name: s.Name, SSHCommand: fmt.Sprintf("test -e %s", s.FileExists)}
+	return probe.runSSHCommand(ctx, client, namespace, vmi, params)
+}
+
+func (s TestSpec) checkSystemdUnit(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	params *api.ArtifactTestParams,
+) error {
+	probe := TestSpec{Name: s.Name, SSHCommand: fmt.Sprintf("systemctl is-active --quiet %s", s.SystemdUnitActive)}
+	return probe.runSSHCommand(ctx, client, namespace, vmi, params)
+}
+
+func (s TestSpec) probeHTTP(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	params *api.ArtifactTestParams,
+) error {
+	probe := TestSpec{
+		Name: s.Name,
+		SSHCommand: fmt.Sprintf(
+			"curl -s -o /dev/null -w '%%{http_code}' http://localhost:%d%s",
+			s.HTTPProbe.Port, s.HTTPProbe.Path,
+		),
+		ExpectedRegex: fmt.Sprintf("^%d$", s.HTTPProbe.ExpectedStatus),
+	}
+	return probe.runSSHCommand(ctx, client, namespace, vmi, params)
+}
+
+// dialSSH opens an SSH session to vmi over a virtctl-style port-forward,
+// authenticating with the private key generated for this verify run.
+func dialSSH(
+	ctx context.Context,
+	client kvirtcli.KubevirtClient,
+	namespace string,
+	vmi *v1.VirtualMachineInstance,
+	params *api.ArtifactTestParams,
+) (session *ssh.Session, closers []io.Closer, err error) {
+	signer, err := ssh.NewSignerFromKey(params.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stream, err := client.VirtualMachineInstance(namespace).PortForward(vmi.Name, sshPort, "tcp")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to port-forward to %s: %w", vmi.Name, err)
+	}
+	closers = append(closers, stream)
+
+	conn, chans, reqs, err := ssh.NewClientConn(
+		stream.AsConn(),
+		net.JoinHostPort(vmi.Name, fmt.Sprintf("%d", sshPort)),
+		&ssh.ClientConfig{
+			User:            params.Username,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		},
+	)
+	if err != nil {
+		return nil, closers, fmt.Errorf("failed to establish ssh connection to %s: %w", vmi.Name, err)
+	}
+
+	sshClient := ssh.NewClient(conn, chans, reqs)
+	closers = append(closers, sshClient)
+
+	session, err = sshClient.NewSession()
+	if err != nil {
+		return nil, closers, err
+	}
+	closers = append(closers, session)
+
+	return session, closers, nil
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeExitError struct{ status int }
+
+func (e *fakeExitError) Error() string   { return "exit error" }
+func (e *fakeExitError) ExitStatus() int { return e.status }
+
+func intPtr(i int) *int { return &i }
+
+func TestEvaluateSSHResult(t *testing.T) {
+	cases := []struct {
+		name             string
+		out              []byte
+		runErr           error
+		expectedExitCode *int
+		expectedRegex    string
+		wantErr          bool
+	}{
+		{
+			name: "exit 0, no expectations",
+			out:  []byte("ok"),
+		},
+		{
+			name:    "command failed to run at all",
+			runErr:  errors.New("dial failed"),
+			wantErr: true,
+		},
+		{
+			name:             "exit code matches expectation",
+			runErr:           &fakeExitError{status: 3},
+			expectedExitCode: intPtr(3),
+		},
+		{
+			name:             "exit code does not match expectation",
+			runErr:           &fakeExitError{status: 1},
+			expectedExitCode: intPtr(3),
+			wantErr:          true,
+		},
+		{
+			name:             "exit 0 when non-zero expected",
+			expectedExitCode: intPtr(3),
+			wantErr:          true,
+		},
+		{
+			name:          "output matches expected regex",
+			out:           []byte("status: done"),
+			expectedRegex: "status: done",
+		},
+		{
+			name:          "output does not match expected regex",
+			out:           []byte("status: running"),
+			expectedRegex: "status: done",
+			wantErr:       true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := evaluateSSHResult("test", "cmd", c.out, c.runErr, c.expectedExitCode, c.expectedRegex)
+			if c.wantErr && err == nil {
+				t.Fatalf("expected an error, got nil")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
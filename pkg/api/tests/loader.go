@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+// LoadDir reads every *.yaml/*.yml file in dir and returns the TestSpecs
+// they declare, in filename order. An empty dir returns no specs and no
+// error, so --tests-dir can be left unset.
+func LoadDir(dir string) ([]TestSpec, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tests dir %q: %w", dir, err)
+	}
+
+	var specs []TestSpec
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read test spec %q: %w", path, err)
+		}
+
+		var fileSpecs []TestSpec
+		if err := yaml.Unmarshal(data, &fileSpecs); err != nil {
+			return nil, fmt.Errorf("failed to parse test spec %q: %w", path, err)
+		}
+
+		for _, spec := range fileSpecs {
+			if err := spec.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid test spec in %q: %w", path, err)
+			}
+			specs = append(specs, spec)
+		}
+	}
+
+	return specs, nil
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDirEmptyPath(t *testing.T) {
+	specs, err := LoadDir("")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if specs != nil {
+		t.Fatalf("expected no specs, got %v", specs)
+	}
+}
+
+func TestLoadDirReadsYAMLFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "checks.yaml", `
+- name: guest-reachable
+  sshCommand: "true"
+- name: disk-mounted
+  fileExists: /mnt/data
+`)
+	writeFile(t, dir, "ignored.txt", "not a spec file")
+
+	specs, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("expected 2 specs, got %d: %+v", len(specs), specs)
+	}
+	if specs[0].Name != "guest-reachable" || specs[1].Name != "disk-mounted" {
+		t.Fatalf("unexpected specs: %+v", specs)
+	}
+}
+
+func TestLoadDirRejectsInvalidSpec(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "bad.yaml", `
+- name: conflicting
+  sshCommand: "true"
+  fileExists: /mnt/data
+`)
+
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatalf("expected an error for a spec setting two check kinds")
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write fixture %s: %v", name, err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package tests provides a declarative alternative to the Go closures
+// returned by api.Artifact.Tests(). A TestSpec describes a single in-guest
+// assertion that verifyArtifact can execute without the check having been
+// compiled into medius, so downstream users can layer extra assertions on
+// top of the built-in ones via --tests-dir.
+package tests
+
+import "fmt"
+
+// TestSpec declares a single in-guest check. Exactly one of SSHCommand,
+// FileExists, SystemdUnitActive or HTTPProbe must be set; Execute rejects a
+// spec that sets more than one or none at all.
+type TestSpec struct {
+	// Name identifies the spec in test reports.
+	Name string `yaml:"name"`
+
+	// SSHCommand, if set, is run over SSH and checked against
+	// ExpectedExitCode and ExpectedRegex.
+	SSHCommand string `yaml:"sshCommand,omitempty"`
+	// ExpectedExitCode defaults to 0 when SSHCommand is set.
+	ExpectedExitCode *int `yaml:"expectedExitCode,omitempty"`
+	// ExpectedRegex, if set, must match the command's combined stdout/stderr.
+	ExpectedRegex string `yaml:"expectedRegex,omitempty"`
+
+	// FileExists, if set, asserts that a path exists in the guest.
+	FileExists string `yaml:"fileExists,omitempty"`
+
+	// SystemdUnitActive, if set, asserts that the named unit is active.
+	SystemdUnitActive string `yaml:"systemdUnitActive,omitempty"`
+
+	// HTTPProbe, if set, asserts an HTTP request from inside the guest
+	// succeeds, reached via a virtctl ssh port-forward.
+	HTTPProbe *HTTPProbe `yaml:"httpProbe,omitempty"`
+}
+
+// HTTPProbe describes an HTTP request to make from inside the guest.
+type HTTPProbe struct {
+	Path           string `yaml:"path"`
+	Port           int    `yaml:"port"`
+	ExpectedStatus int    `yaml:"expectedStatus"`
+}
+
+// Validate ensures exactly one check kind is set on the spec.
+func (s TestSpec) Validate() error {
+	set := 0
+	for _, has := range []bool{s.SSHCommand != "", s.FileExists != "", s.SystemdUnitActive != "", s.HTTPProbe != nil} {
+		if has {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return fmt.Errorf("test spec %q must set exactly one of sshCommand, fileExists, systemdUnitActive, httpProbe", s.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package tests
+
+import "testing"
+
+func TestTestSpecValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    TestSpec
+		wantErr bool
+	}{
+		{
+			name: "sshCommand only",
+			spec: TestSpec{Name: "ssh", SSHCommand: "true"},
+		},
+		{
+			name: "fileExists only",
+			spec: TestSpec{Name: "file", FileExists: "/etc/hostname"},
+		},
+		{
+			name: "systemdUnitActive only",
+			spec: TestSpec{Name: "unit", SystemdUnitActive: "sshd"},
+		},
+		{
+			name: "httpProbe only",
+			spec: TestSpec{Name: "http", HTTPProbe: &HTTPProbe{Path: "/", Port: 80, ExpectedStatus: 200}},
+		},
+		{
+			name:    "none set",
+			spec:    TestSpec{Name: "empty"},
+			wantErr: true,
+		},
+		{
+			name: "two set",
+			spec: TestSpec{
+				Name:              "conflicting",
+				SSHCommand:        "true",
+				SystemdUnitActive: "sshd",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.spec.Validate()
+			if c.wantErr && err == nil {
+				t.Fatalf("expected an error, got nil")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}